@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/emersion/go-webdav"
+	"github.com/emersion/go-webdav/caldav"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/api/calendar/v3"
+)
+
+// CalDAVSink upserts scraped Cybozu events as VEVENTs into a user-specified
+// CalDAV collection, e.g. a Nextcloud or Radicale calendar.
+type CalDAVSink struct {
+	client        *caldav.Client
+	collectionURL string
+}
+
+func getCalDAVSink() *CalDAVSink {
+	collectionURL := getConfig("C2G_CALDAV_URL")
+	user := os.Getenv("C2G_CALDAV_USER")
+	password := os.Getenv("C2G_CALDAV_PASSWORD")
+
+	httpClient := webdav.HTTPClientWithBasicAuth(http.DefaultClient, user, password)
+	client, err := caldav.NewClient(httpClient, collectionURL)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Unable to create CalDAV client")
+	}
+
+	return &CalDAVSink{client: client, collectionURL: collectionURL}
+}
+
+func (this *CalDAVSink) Upsert(event *calendar.Event) error {
+	vevent, err := eventToVEvent(event)
+	if err != nil {
+		return err
+	}
+
+	cal := newICalCalendar()
+	cal.Children = append(cal.Children, vevent.Component)
+
+	_, err = this.client.PutCalendarObject(context.Background(), this.objectPath(event.Id), cal)
+	return err
+}
+
+func (this *CalDAVSink) DeleteEvent(event *calendar.Event) error {
+	return this.client.RemoveAll(context.Background(), this.objectPath(event.Id))
+}
+
+func (this *CalDAVSink) objectPath(eventId string) string {
+	return this.collectionURL + eventId + ".ics"
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// runDaemon runs runSync on the schedule in C2G_SCHEDULE (a standard 5-field
+// cron expression, default every 15 minutes) until the process is killed,
+// alongside an HTTP server exposing /healthz and /metrics for operators.
+func runDaemon(gcal CalendarSink) {
+	schedule := os.Getenv("C2G_SCHEDULE")
+	if schedule == "" {
+		schedule = "*/15 * * * *"
+	}
+
+	c := cron.New()
+	if _, err := c.AddFunc(schedule, func() { runSync(gcal) }); err != nil {
+		log.Fatal().Err(err).Msgf("Invalid C2G_SCHEDULE %q", schedule)
+	}
+
+	go serveMetrics()
+
+	log.Info().Msgf("Running in daemon mode on schedule %q", schedule)
+	c.Run()
+}
+
+// serveMetrics serves /healthz and /metrics on C2G_METRICS_ADDR (default
+// ":9090") for the lifetime of the daemon.
+func serveMetrics() {
+	addr := os.Getenv("C2G_METRICS_ADDR")
+	if addr == "" {
+		addr = ":9090"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+
+	log.Info().Msgf("Serving /healthz and /metrics on %v", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Fatal().Err(err).Msg("Unable to serve /healthz and /metrics")
+	}
+}
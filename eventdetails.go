@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/semaphore"
+	"google.golang.org/api/calendar/v3"
+)
+
+// CybozuEventDetail is the part of a ScheduleView detail page that the
+// month/day view anchors don't carry: メモ (description), 場所 (location),
+// 施設 (facilities) and 参加者 (attendees).
+type CybozuEventDetail struct {
+	Description string
+	Location    string
+	Attendees   []*calendar.EventAttendee
+}
+
+var (
+	detailSemOnce sync.Once
+	detailSem     *semaphore.Weighted
+)
+
+// getDetailSemaphore bounds how many ScheduleView detail fetches run at
+// once, so we don't hammer the Cybozu server alongside the existing
+// per-event goroutine pool. Size is configurable via
+// C2G_DETAIL_CONCURRENCY (default 4).
+func getDetailSemaphore() *semaphore.Weighted {
+	detailSemOnce.Do(func() {
+		size := int64(4)
+		if v := os.Getenv("C2G_DETAIL_CONCURRENCY"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				size = int64(n)
+			}
+		}
+		detailSem = semaphore.NewWeighted(size)
+	})
+	return detailSem
+}
+
+// fetchEventDetails GETs a Cybozu ScheduleView page for sEID on date
+// (formatted "2006.01.02") and parses its detail table.
+func fetchEventDetails(agsessid, sEID, date string) (*CybozuEventDetail, error) {
+	sem := getDetailSemaphore()
+	ctx := context.Background()
+	if err := sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	defer sem.Release(1)
+
+	url := baseURL + "?page=ScheduleView&sEID=" + sEID + "&Date=da." + date
+	node, err := cybozuHtml(agsessid, cybozuUserID, userID, url)
+	if err != nil {
+		return nil, err
+	}
+	doc := goquery.NewDocumentFromNode(node)
+
+	location := strings.TrimSpace(doc.Find(".scheduleLocation").Text())
+	if facilities := strings.TrimSpace(doc.Find(".scheduleFacility").Text()); facilities != "" {
+		if location != "" {
+			location += ", " + facilities
+		} else {
+			location = facilities
+		}
+	}
+
+	return &CybozuEventDetail{
+		Description: strings.TrimSpace(doc.Find(".scheduleMemo").Text()),
+		Location:    location,
+		Attendees:   attendeesOf(doc),
+	}, nil
+}
+
+func attendeesOf(doc *goquery.Document) []*calendar.EventAttendee {
+	userMap := loadUserMap()
+	var attendees []*calendar.EventAttendee
+	doc.Find(".scheduleParticipant .name").Each(func(i int, s *goquery.Selection) {
+		name := strings.TrimSpace(s.Text())
+		email, ok := userMap[name]
+		if !ok {
+			log.Warn().Msgf("No C2G_USER_MAP entry for Cybozu user %q, skipping attendee", name)
+			return
+		}
+		attendees = append(attendees, &calendar.EventAttendee{Email: email})
+	})
+	return attendees
+}
+
+var (
+	userMapOnce sync.Once
+	userMap     map[string]string
+)
+
+// loadUserMap reads the JSON file at C2G_USER_MAP, mapping Cybozu display
+// names to email addresses, e.g. {"山田太郎": "yamada@example.com"}.
+func loadUserMap() map[string]string {
+	userMapOnce.Do(func() {
+		userMap = map[string]string{}
+		path := os.Getenv("C2G_USER_MAP")
+		if path == "" {
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			log.Error().Err(err).Msgf("Unable to open C2G_USER_MAP %v", path)
+			return
+		}
+		defer f.Close()
+		if err := json.NewDecoder(f).Decode(&userMap); err != nil {
+			log.Error().Err(err).Msgf("Unable to parse C2G_USER_MAP %v", path)
+		}
+	})
+	return userMap
+}
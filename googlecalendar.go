@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/calendar/v3"
+)
+
+func getGcal() *GoogleCalendar {
+	ctx := context.Background()
+
+	b, err := ioutil.ReadFile(configFilePath("client_secret.json"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Unable to read client secret file")
+	}
+
+	config, err := google.ConfigFromJSON(b, calendar.CalendarScope)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Unable to parse client secret file to config")
+	}
+
+	client := getClient(ctx, config)
+	return NewGoogleCalendar(client, calendarId)
+}
+
+type GoogleCalendar struct {
+	calendarId string
+	svc        *calendar.Service
+}
+
+func NewGoogleCalendar(client *http.Client, calendarId string) *GoogleCalendar {
+	this := &GoogleCalendar{}
+	this.svc, _ = calendar.New(client)
+	this.calendarId = calendarId
+	return this
+}
+
+func (this *GoogleCalendar) Upsert(event *calendar.Event) error {
+	err := doWithBackoff(func() error {
+		_, err := this.svc.Events.Update(this.calendarId, event.Id, event).Do()
+		return err
+	})
+	if err != nil {
+		err = doWithBackoff(func() error {
+			_, err := this.svc.Events.Insert(this.calendarId, event).Do()
+			return err
+		})
+	}
+	return err
+}
+
+func (this *GoogleCalendar) DeleteEvent(event *calendar.Event) error {
+	return doWithBackoff(func() error {
+		return this.svc.Events.Delete(this.calendarId, event.Id).Do()
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/api/calendar/v3"
+)
+
+// ICalSink writes every scraped Cybozu event into a single .ics file on
+// disk, so Cybozu schedules can be imported into any calendar app that
+// understands RFC 5545 (Nextcloud, Radicale, Apple Calendar, ...).
+type ICalSink struct {
+	path string
+
+	// mu serializes Upsert/DeleteEvent, which are each a read-modify-write
+	// of the whole file. main.go calls them from one goroutine per scraped
+	// event, so without this they'd race and clobber each other's writes.
+	mu sync.Mutex
+}
+
+func getICalSink() *ICalSink {
+	path := os.Getenv("C2G_ICS_PATH")
+	if path == "" {
+		path = configFilePath("cybozu8.ics")
+	}
+	return &ICalSink{path: path}
+}
+
+func (this *ICalSink) Upsert(event *calendar.Event) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	cal, err := this.load()
+	if err != nil {
+		return err
+	}
+
+	vevent, err := eventToVEvent(event)
+	if err != nil {
+		return err
+	}
+
+	replaced := false
+	for i, child := range cal.Children {
+		if child.Name == ical.CompEvent && uidOf(child) == event.Id {
+			cal.Children[i] = vevent.Component
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		cal.Children = append(cal.Children, vevent.Component)
+	}
+
+	return this.save(cal)
+}
+
+func (this *ICalSink) DeleteEvent(event *calendar.Event) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	cal, err := this.load()
+	if err != nil {
+		return err
+	}
+
+	kept := cal.Children[:0]
+	for _, child := range cal.Children {
+		if child.Name == ical.CompEvent && uidOf(child) == event.Id {
+			continue
+		}
+		kept = append(kept, child)
+	}
+	cal.Children = kept
+
+	return this.save(cal)
+}
+
+// load reads the existing .ics file, or returns a fresh empty calendar if
+// it doesn't exist yet.
+func (this *ICalSink) load() (*ical.Calendar, error) {
+	f, err := os.Open(this.path)
+	if os.IsNotExist(err) {
+		return newICalCalendar(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ical.NewDecoder(f).Decode()
+}
+
+func (this *ICalSink) save(cal *ical.Calendar) error {
+	f, err := os.Create(this.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := ical.NewEncoder(f).Encode(cal); err != nil {
+		return err
+	}
+	log.Info().Msgf("Wrote %d events to %v", len(cal.Children), this.path)
+	return nil
+}
+
+func newICalCalendar() *ical.Calendar {
+	cal := ical.NewCalendar()
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	cal.Props.SetText(ical.PropProductID, "-//haya14busa/cybouze8-2-googlecalendar//EN")
+	return cal
+}
+
+// eventToVEvent converts a calendar.Event, as built by updateEvent and
+// updateBannerEvent, into a go-ical VEVENT.
+func eventToVEvent(event *calendar.Event) (*ical.Event, error) {
+	vevent := ical.NewEvent()
+	vevent.Props.SetText(ical.PropUID, event.Id)
+	vevent.Props.SetDateTime(ical.PropDateTimeStamp, time.Now().UTC())
+	vevent.Props.SetText(ical.PropSummary, event.Summary)
+	if event.Description != "" {
+		vevent.Props.SetText(ical.PropDescription, event.Description)
+	}
+	if event.Location != "" {
+		vevent.Props.SetText(ical.PropLocation, event.Location)
+	}
+	for _, attendee := range event.Attendees {
+		prop := ical.NewProp(ical.PropAttendee)
+		prop.Value = "mailto:" + attendee.Email
+		vevent.Props.Add(prop)
+	}
+
+	loc, _ := time.LoadLocation("Asia/Tokyo")
+	if err := setVEventDate(vevent.Props, ical.PropDateTimeStart, event.Start, loc); err != nil {
+		return nil, err
+	}
+	if err := setVEventDate(vevent.Props, ical.PropDateTimeEnd, event.End, loc); err != nil {
+		return nil, err
+	}
+
+	if kind := cybozuKind(event); kind != "" {
+		vevent.Props.SetText("X-CYBOZU-KIND", kind)
+	}
+
+	return vevent, nil
+}
+
+func setVEventDate(props ical.Props, name string, d *calendar.EventDateTime, loc *time.Location) error {
+	if d.DateTime != "" {
+		t, err := time.Parse(time.RFC3339, d.DateTime)
+		if err != nil {
+			return err
+		}
+		props.SetDateTime(name, t.In(loc))
+		return nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", d.Date, loc)
+	if err != nil {
+		return err
+	}
+	props.SetDate(name, t)
+	return nil
+}
+
+// cybozuKind reports whether event came from a .bannerevent or a regular
+// .event, based on the extended property set by main.go.
+func cybozuKind(event *calendar.Event) string {
+	if event.ExtendedProperties == nil || event.ExtendedProperties.Private == nil {
+		return ""
+	}
+	return event.ExtendedProperties.Private["cybozuKind"]
+}
+
+func uidOf(component *ical.Component) string {
+	prop := component.Props.Get(ical.PropUID)
+	if prop == nil {
+		return ""
+	}
+	return prop.Value
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-ical"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestICalSinkUpsertRoundTrip(t *testing.T) {
+	sink := &ICalSink{path: filepath.Join(t.TempDir(), "cybozu8.ics")}
+
+	event := &calendar.Event{
+		Id:          "1234",
+		Summary:     "Weekly sync",
+		Description: "Status update",
+		Location:    "Room A",
+		Start:       &calendar.EventDateTime{Date: "2024-01-01", TimeZone: "Asia/Tokyo"},
+		End:         &calendar.EventDateTime{Date: "2024-01-01", TimeZone: "Asia/Tokyo"},
+		Attendees:   []*calendar.EventAttendee{{Email: "alice@example.com"}},
+	}
+	if err := sink.Upsert(event); err != nil {
+		t.Fatalf("Upsert: %v", err)
+	}
+
+	cal, err := sink.load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	vevents := cal.Events()
+	if len(vevents) != 1 {
+		t.Fatalf("got %d VEVENTs, want 1", len(vevents))
+	}
+
+	got := vevents[0]
+	if text, _ := got.Props.Text(ical.PropSummary); text != event.Summary {
+		t.Errorf("Summary = %q, want %q", text, event.Summary)
+	}
+	if text, _ := got.Props.Text(ical.PropDescription); text != event.Description {
+		t.Errorf("Description = %q, want %q", text, event.Description)
+	}
+	if text, _ := got.Props.Text(ical.PropLocation); text != event.Location {
+		t.Errorf("Location = %q, want %q", text, event.Location)
+	}
+	if prop := got.Props.Get(ical.PropAttendee); prop == nil || prop.Value != "mailto:alice@example.com" {
+		t.Errorf("Attendee = %+v, want mailto:alice@example.com", prop)
+	}
+}
+
+func TestICalSinkUpsertReplacesExistingEvent(t *testing.T) {
+	sink := &ICalSink{path: filepath.Join(t.TempDir(), "cybozu8.ics")}
+
+	original := &calendar.Event{
+		Id:      "1234",
+		Summary: "Original title",
+		Start:   &calendar.EventDateTime{Date: "2024-01-01"},
+		End:     &calendar.EventDateTime{Date: "2024-01-01"},
+	}
+	updated := &calendar.Event{
+		Id:      "1234",
+		Summary: "Updated title",
+		Start:   &calendar.EventDateTime{Date: "2024-01-01"},
+		End:     &calendar.EventDateTime{Date: "2024-01-01"},
+	}
+
+	if err := sink.Upsert(original); err != nil {
+		t.Fatalf("Upsert(original): %v", err)
+	}
+	if err := sink.Upsert(updated); err != nil {
+		t.Fatalf("Upsert(updated): %v", err)
+	}
+
+	cal, err := sink.load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	vevents := cal.Events()
+	if len(vevents) != 1 {
+		t.Fatalf("got %d VEVENTs, want 1 (replaced, not appended)", len(vevents))
+	}
+	if text, _ := vevents[0].Props.Text(ical.PropSummary); text != updated.Summary {
+		t.Errorf("Summary = %q, want %q", text, updated.Summary)
+	}
+}
+
+func TestICalSinkDeleteEvent(t *testing.T) {
+	sink := &ICalSink{path: filepath.Join(t.TempDir(), "cybozu8.ics")}
+
+	kept := &calendar.Event{
+		Id:      "1111",
+		Summary: "Kept event",
+		Start:   &calendar.EventDateTime{Date: "2024-01-01"},
+		End:     &calendar.EventDateTime{Date: "2024-01-01"},
+	}
+	removed := &calendar.Event{
+		Id:      "2222",
+		Summary: "Removed event",
+		Start:   &calendar.EventDateTime{Date: "2024-01-02"},
+		End:     &calendar.EventDateTime{Date: "2024-01-02"},
+	}
+	if err := sink.Upsert(kept); err != nil {
+		t.Fatalf("Upsert(kept): %v", err)
+	}
+	if err := sink.Upsert(removed); err != nil {
+		t.Fatalf("Upsert(removed): %v", err)
+	}
+	if err := sink.DeleteEvent(removed); err != nil {
+		t.Fatalf("DeleteEvent: %v", err)
+	}
+
+	cal, err := sink.load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	vevents := cal.Events()
+	if len(vevents) != 1 {
+		t.Fatalf("got %d VEVENTs, want 1 after delete", len(vevents))
+	}
+	if uidOf(vevents[0].Component) != kept.Id {
+		t.Errorf("remaining VEVENT UID = %q, want %q", uidOf(vevents[0].Component), kept.Id)
+	}
+}
@@ -5,10 +5,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"os"
@@ -18,17 +17,23 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/PuerkitoBio/goquery"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"golang.org/x/net/context"
 	"golang.org/x/net/html"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 	"golang.org/x/text/encoding/japanese"
 	"golang.org/x/text/transform"
 	"google.golang.org/api/calendar/v3"
+)
 
-	"github.com/PuerkitoBio/goquery"
+var (
+	logJSON = flag.Bool("log.json", false, "log in structured JSON instead of a human-readable console format")
+	daemon  = flag.Bool("daemon", false, "run on the schedule in C2G_SCHEDULE instead of syncing once and exiting")
 )
 
 var (
@@ -52,35 +57,92 @@ func initConfig() {
 func getConfig(key string) string {
 	r := os.Getenv(key)
 	if r == "" {
-		log.Fatalf("Environment variable not set: %s", key)
+		log.Fatal().Msgf("Environment variable not set: %s", key)
 	}
 	return r
 }
 
 func main() {
-	log.Println("===Start: cybozu8togcal===")
+	flag.Parse()
+	if !*logJSON {
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
+	}
 
 	initConfig()
+	gcal := getSink()
+
+	if *daemon {
+		runDaemon(gcal)
+		return
+	}
+	runSync(gcal)
+}
 
-	gcal := getGcal()
+// runSync does one full scrape-and-sync pass: it logs in to Cybozu, scrapes
+// the current month's schedule, upserts every event into gcal and deletes
+// whatever's now stale, then logs a structured summary of what changed.
+func runSync(gcal CalendarSink) {
+	start := time.Now()
+	log.Info().Msg("===Start: cybozu8togcal===")
 
 	agsessid, err := getAGSESSID()
 	if err != nil {
-		log.Fatalf("Cannot get AGSESSID from cybozu", err)
+		log.Fatal().Err(err).Msg("Cannot get AGSESSID from cybozu")
 	}
 
 	node := calendarHtml(agsessid, cybozuUserID, userID)
 
 	doc := goquery.NewDocumentFromNode(node)
 
-	gcal.DeleteUpcomingEvents()
+	state, err := loadSyncState()
+	if err != nil {
+		log.Warn().Err(err).Msg("Unable to load sync state, starting fresh")
+		state = newSyncState()
+	}
+
+	if g, ok := gcal.(*GoogleCalendar); ok {
+		if err := g.pullServerChanges(state); err != nil {
+			log.Warn().Err(err).Msg("Unable to pull server-side changes")
+		}
+	}
+
+	recurring := fetchRecurringEvents(agsessid, doc)
 
 	var waitGroup sync.WaitGroup
+	var seenMu sync.Mutex
+	seen := map[string]bool{}
+	var upserted int32
+
+	track := func(event *calendar.Event) {
+		if event == nil {
+			return
+		}
+		seenMu.Lock()
+		seen[event.Id] = true
+		seenMu.Unlock()
+		if syncEvent(gcal, state, event) {
+			atomic.AddInt32(&upserted, 1)
+		}
+	}
+
+	for _, event := range recurring {
+		waitGroup.Add(1)
+		go func(event *calendar.Event) {
+			defer waitGroup.Done()
+			track(event)
+		}(event)
+	}
+
 	doc.Find(".event").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		if _, ok := recurring[sEIDFromHref(href)]; ok {
+			// Handled once as a recurring event above.
+			return
+		}
 		waitGroup.Add(1)
 		go func(s *goquery.Selection) {
 			defer waitGroup.Done()
-			updateEvent(gcal, s)
+			track(updateEvent(s, agsessid))
 		}(s)
 	})
 
@@ -88,104 +150,29 @@ func main() {
 		waitGroup.Add(1)
 		go func(s *goquery.Selection) {
 			defer waitGroup.Done()
-			updateBannerEvent(gcal, s, agsessid)
+			track(updateBannerEvent(s, agsessid))
 		}(s)
 	})
 	waitGroup.Wait()
 
-	log.Println("===Finish: cybozu8togcal===")
-}
-
-func getGcal() *GoogleCalendar {
-	ctx := context.Background()
-
-	b, err := ioutil.ReadFile(configFilePath("client_secret.json"))
-	if err != nil {
-		log.Fatalf("Unable to read client secret file: %v", err)
-	}
-
-	config, err := google.ConfigFromJSON(b, calendar.CalendarScope)
-	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
-	}
-
-	client := getClient(ctx, config)
-	return NewGoogleCalendar(client, calendarId)
-}
-
-type GoogleCalendar struct {
-	calendarId string
-	svc        *calendar.Service
-}
-
-func NewGoogleCalendar(client *http.Client, calendarId string) *GoogleCalendar {
-	this := &GoogleCalendar{}
-	this.svc, _ = calendar.New(client)
-	this.calendarId = calendarId
-	return this
-}
+	deleted := cleanupStaleEvents(gcal, state, seen)
 
-func (this *GoogleCalendar) Upsert(event *calendar.Event) (*calendar.Event, error) {
-	ret, err := this.svc.Events.Update(this.calendarId, event.Id, event).Do()
-	if err != nil {
-		ret, err = this.svc.Events.Insert(this.calendarId, event).Do()
-		if err != nil {
-			rateLimitExeeded, _ := regexp.MatchString("403: Rate Limit Exceeded", err.Error())
-			if rateLimitExeeded {
-				log.Printf("Unable to upsert event '%v'. retry after 10 seconds: %v", event.Summary, err)
-				time.Sleep(10 * time.Second)
-				return this.Upsert(event)
-			}
-			return nil, err
-		}
+	if err := state.save(); err != nil {
+		log.Error().Err(err).Msg("Unable to save sync state")
 	}
-	return ret, nil
-}
-
-func (this *GoogleCalendar) DeleteUpcomingEvents() error {
-	allEvents, err := this.svc.Events.List(calendarId).Do()
-	tommorow := time.Now().AddDate(0, 0, 1)
-	if err != nil {
-		return err
-	}
-	var waitGroup sync.WaitGroup
-	for _, item := range allEvents.Items {
-		waitGroup.Add(1)
-		go func(item *calendar.Event) {
-			defer waitGroup.Done()
-			startTime, err := time.Parse(time.RFC3339, item.Start.DateTime)
-			if err == nil && startTime.Before(tommorow) {
-				return
-			}
-			startDate, err := time.Parse("2006-01-02", item.Start.DateTime)
-			if err == nil && startDate.Before(tommorow) {
-				return
-			}
-			if err := this.DeleteEvent(item); err != nil {
-				log.Printf("Unable to delete event: %v", err)
-			} else {
-				log.Printf("delete upcoming event: %v", item.Summary)
-			}
-		}(item)
-	}
-	waitGroup.Wait()
-	return nil
-}
 
-func (this *GoogleCalendar) DeleteEvent(event *calendar.Event) error {
-	err := this.svc.Events.Delete(this.calendarId, event.Id).Do()
-	if err != nil {
-		rateLimitExeeded, _ := regexp.MatchString("403: Rate Limit Exceeded", err.Error())
-		if rateLimitExeeded {
-			log.Printf("Unable to delete event '%v'. retry after 10 seconds: %v", event.Summary, err)
-			time.Sleep(10 * time.Second)
-			return this.DeleteEvent(event)
-		}
-	}
-	return err
+	elapsed := time.Since(start)
+	cybozuScrapeDurationSeconds.Observe(elapsed.Seconds())
+	log.Info().
+		Int32("upserted", upserted).
+		Int("deleted", deleted).
+		Dur("elapsed", elapsed).
+		Msg("===Finish: cybozu8togcal===")
 }
 
-func updateBannerEvent(gcal *GoogleCalendar, s *goquery.Selection, agsessid string) {
+// updateBannerEvent scrapes a .bannerevent anchor into a calendar.Event. It
+// returns nil if the event's date couldn't be parsed.
+func updateBannerEvent(s *goquery.Selection, agsessid string) *calendar.Event {
 	href, _ := s.Attr("href")
 	queryParamRe := regexp.MustCompile(`\?.*$`)
 	queryParam := queryParamRe.FindString(href)
@@ -193,8 +180,8 @@ func updateBannerEvent(gcal *GoogleCalendar, s *goquery.Selection, agsessid stri
 	url := baseURL + strings.Replace(queryParam, "?page=ScheduleView", "?page=ScheduleBannerModify", 1)
 	node, err := cybozuHtml(agsessid, cybozuUserID, userID, url)
 	if err != nil {
-		log.Printf("fail to get html node: %v", err)
-		return
+		log.Error().Err(err).Msg("fail to get html node")
+		return nil
 	}
 	doc := goquery.NewDocumentFromNode(node)
 	startYear, err := selectedIntValue(doc, "SetDate.Year")
@@ -204,8 +191,8 @@ func updateBannerEvent(gcal *GoogleCalendar, s *goquery.Selection, agsessid stri
 	endMonth, err := selectedIntValue(doc, "EndDate.Month")
 	endDay, err := selectedIntValue(doc, "EndDate.Day")
 	if err != nil {
-		log.Printf("Cannot parse event date: %v", err)
-		return
+		log.Error().Err(err).Msg("Cannot parse event date")
+		return nil
 	}
 
 	title, _ := s.Attr("title")
@@ -216,8 +203,8 @@ func updateBannerEvent(gcal *GoogleCalendar, s *goquery.Selection, agsessid stri
 	startDate := startDateTime.Format("2006-01-02")
 	endDate := endDateTime.Format("2006-01-02")
 
-	eventIdRe := regexp.MustCompile(`sEID=([\d]+)`)
-	eventId := eventIdRe.FindStringSubmatch(href)[1] + fmt.Sprintf("%d%d%d", startYear, startMonth, startDay)
+	sEID := sEIDFromHref(href)
+	eventId := sEID + fmt.Sprintf("%d%d%d", startYear, startMonth, startDay)
 
 	event := &calendar.Event{
 		Id:      eventId,
@@ -230,13 +217,32 @@ func updateBannerEvent(gcal *GoogleCalendar, s *goquery.Selection, agsessid stri
 			Date:     endDate,
 			TimeZone: "Asia/Tokyo",
 		},
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{"cybozuKind": "banner", "cybozuSEID": sEID},
+		},
 	}
 
-	if _, err := gcal.Upsert(event); err != nil {
-		log.Printf("Unable to upsert bannerevent '%v': %v", event.Summary, err)
+	if detail, err := fetchEventDetails(agsessid, sEID, startDateTime.Format("2006.01.02")); err != nil {
+		log.Warn().Err(err).Msgf("Unable to fetch bannerevent details for sEID=%v", sEID)
 	} else {
-		log.Printf("Succeed to update bannerevent %v-%v, %v", startDate, endDate, title)
+		event.Description = detail.Description
+		event.Location = detail.Location
+		event.Attendees = detail.Attendees
 	}
+
+	return event
+}
+
+var sEIDRe = regexp.MustCompile(`sEID=([\d]+)`)
+
+// sEIDFromHref extracts the Cybozu schedule event ID from a ScheduleView
+// anchor's href, e.g. "...&sEID=1234&..." -> "1234".
+func sEIDFromHref(href string) string {
+	m := sEIDRe.FindStringSubmatch(href)
+	if m == nil {
+		return ""
+	}
+	return m[1]
 }
 
 func selectedIntValue(doc *goquery.Document, name string) (int, error) {
@@ -255,7 +261,10 @@ func selectedIntValue(doc *goquery.Document, name string) (int, error) {
 	return 0, fmt.Errorf("selected value doesn't exist for '%s'", name)
 }
 
-func updateEvent(gcal *GoogleCalendar, s *goquery.Selection) {
+// updateEvent scrapes a .event anchor into a calendar.Event. It returns nil
+// if the event's date couldn't be parsed, or if it's old enough that it's
+// not worth updating.
+func updateEvent(s *goquery.Selection, agsessid string) *calendar.Event {
 	href, _ := s.Attr("href")
 	re := regexp.MustCompile("Date=da\\.(?P<year>[\\d]{4})\\.(?P<month>[\\d]{1,2})\\.(?P<day>[\\d]{1,2})")
 	matches := re.FindStringSubmatch(href)
@@ -263,8 +272,8 @@ func updateEvent(gcal *GoogleCalendar, s *goquery.Selection) {
 	month, err := strconv.Atoi(matches[2])
 	day, err := strconv.Atoi(matches[3])
 	if err != nil {
-		log.Printf("Cannot parse event date: %v", err)
-		return
+		log.Error().Err(err).Msg("Cannot parse event date")
+		return nil
 	}
 
 	loc, _ := time.LoadLocation("Asia/Tokyo")
@@ -272,12 +281,12 @@ func updateEvent(gcal *GoogleCalendar, s *goquery.Selection) {
 	dateTime := time.Date(year, time.Month(month), day, 0, 0, 0, 0, loc)
 	if dateTime.Before(time.Now().AddDate(0, 0, -7)) {
 		// Update upcoming events, not old events
-		return
+		return nil
 	}
 	date := dateTime.Format("2006-01-02")
 
-	eventIdRe := regexp.MustCompile(`sEID=([\d]+)`)
-	eventId := eventIdRe.FindStringSubmatch(href)[1] + fmt.Sprintf("%d%d%d", year, month, day)
+	sEID := sEIDFromHref(href)
+	eventId := sEID + fmt.Sprintf("%d%d%d", year, month, day)
 	title := s.Find(".eventTitle").Text()
 
 	eventTimeRe := regexp.MustCompile(`^(\d{2}):(\d{2})(?:-(\d{2}):(\d{2}))?`)
@@ -289,6 +298,9 @@ func updateEvent(gcal *GoogleCalendar, s *goquery.Selection) {
 	event := &calendar.Event{
 		Id:      eventId,
 		Summary: title,
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{"cybozuKind": "event", "cybozuSEID": sEID},
+		},
 	}
 
 	if len(eventTime) == 5 { // Match!
@@ -323,11 +335,15 @@ func updateEvent(gcal *GoogleCalendar, s *goquery.Selection) {
 		}
 	}
 
-	if _, err := gcal.Upsert(event); err != nil {
-		log.Printf("Unable to upsert event '%v': %v", event.Summary, err)
+	if detail, err := fetchEventDetails(agsessid, sEID, dateTime.Format("2006.01.02")); err != nil {
+		log.Warn().Err(err).Msgf("Unable to fetch event details for sEID=%v", sEID)
 	} else {
-		log.Printf("Succeed to update event %v, %v", date, title)
+		event.Description = detail.Description
+		event.Location = detail.Location
+		event.Attendees = detail.Attendees
 	}
+
+	return event
 }
 
 func getAGSESSID() (string, error) {
@@ -404,7 +420,7 @@ func cybozuHtml(agsessid, loginid, userID, url string) (*html.Node, error) {
 func getClient(ctx context.Context, config *oauth2.Config) *http.Client {
 	cacheFile, err := tokenCacheFile()
 	if err != nil {
-		log.Fatalf("Unable to get path to cached credential file. %v", err)
+		log.Fatal().Err(err).Msg("Unable to get path to cached credential file")
 	}
 	tok, err := tokenFromFile(cacheFile)
 	if err != nil {
@@ -423,12 +439,12 @@ func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
 
 	var code string
 	if _, err := fmt.Scan(&code); err != nil {
-		log.Fatalf("Unable to read authorization code %v", err)
+		log.Fatal().Err(err).Msg("Unable to read authorization code")
 	}
 
 	tok, err := config.Exchange(oauth2.NoContext, code)
 	if err != nil {
-		log.Fatalf("Unable to retrieve token from web %v", err)
+		log.Fatal().Err(err).Msg("Unable to retrieve token from web")
 	}
 	return tok
 }
@@ -458,7 +474,7 @@ func saveToken(file string, token *oauth2.Token) {
 	fmt.Printf("Saving credential file to: %s\n", file)
 	f, err := os.Create(file)
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		log.Fatal().Err(err).Msg("Unable to cache oauth token")
 	}
 	defer f.Close()
 	json.NewEncoder(f).Encode(token)
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	eventsUpsertedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "c2g_events_upserted_total",
+		Help: "Total number of Cybozu events upserted into the configured calendar sink.",
+	})
+
+	cybozuScrapeDurationSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "c2g_cybozu_scrape_duration_seconds",
+		Help: "Time taken to scrape and sync one run of Cybozu's schedule.",
+	})
+
+	gcalRetriesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "c2g_gcal_ratelimit_retries_total",
+		Help: "Total number of Google Calendar API calls retried due to rate limiting or transient errors.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(eventsUpsertedTotal, cybozuScrapeDurationSeconds, gcalRetriesTotal)
+}
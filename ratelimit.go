@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	backoffBase        = 1 * time.Second
+	backoffFactor      = 2
+	backoffMax         = 64 * time.Second
+	backoffMaxAttempts = 6
+)
+
+var (
+	gcalLimiterOnce sync.Once
+	gcalLimiter     *rate.Limiter
+)
+
+// getGcalLimiter returns the rate.Limiter shared by every goroutine that
+// calls the Google Calendar API, so main's worker pool doesn't exceed
+// the configured QPS. Default 5 req/s, configurable via C2G_GCAL_QPS.
+func getGcalLimiter() *rate.Limiter {
+	gcalLimiterOnce.Do(func() {
+		qps := 5.0
+		if v := os.Getenv("C2G_GCAL_QPS"); v != "" {
+			if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 {
+				qps = f
+			}
+		}
+		gcalLimiter = rate.NewLimiter(rate.Limit(qps), 1)
+	})
+	return gcalLimiter
+}
+
+// doWithBackoff runs fn behind the shared rate limiter, retrying with
+// exponential backoff and jitter on Google API rate-limit errors
+// (403 rateLimitExceeded/userRateLimitExceeded/quotaExceeded) and
+// transient 500/503s, honoring a Retry-After header when the API sends
+// one.
+func doWithBackoff(fn func() error) error {
+	var err error
+	backoff := backoffBase
+	for attempt := 0; attempt < backoffMaxAttempts; attempt++ {
+		if waitErr := getGcalLimiter().Wait(context.Background()); waitErr != nil {
+			return waitErr
+		}
+
+		err = fn()
+		if err == nil {
+			return nil
+		}
+
+		gerr, ok := err.(*googleapi.Error)
+		if !ok || !isRetryableError(gerr) {
+			return err
+		}
+
+		wait := backoff
+		if retryAfter := retryAfterOf(gerr); retryAfter > 0 {
+			wait = retryAfter
+		}
+		wait += time.Duration(rand.Int63n(int64(wait)/2 + 1)) // jitter
+
+		log.Warn().Err(err).Msgf("Google API call failed (attempt %d/%d), retrying in %v", attempt+1, backoffMaxAttempts, wait)
+		gcalRetriesTotal.Inc()
+		time.Sleep(wait)
+
+		backoff *= backoffFactor
+		if backoff > backoffMax {
+			backoff = backoffMax
+		}
+	}
+	return err
+}
+
+func isRetryableError(gerr *googleapi.Error) bool {
+	if gerr.Code == http.StatusInternalServerError || gerr.Code == http.StatusServiceUnavailable {
+		return true
+	}
+	if gerr.Code != http.StatusForbidden {
+		return false
+	}
+	for _, e := range gerr.Errors {
+		switch e.Reason {
+		case "rateLimitExceeded", "userRateLimitExceeded", "quotaExceeded":
+			return true
+		}
+	}
+	return false
+}
+
+func retryAfterOf(gerr *googleapi.Error) time.Duration {
+	if gerr.Header == nil {
+		return 0
+	}
+	secs, err := strconv.Atoi(gerr.Header.Get("Retry-After"))
+	if err != nil {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/rs/zerolog/log"
+	"github.com/teambition/rrule-go"
+	"google.golang.org/api/calendar/v3"
+)
+
+// fetchRecurringEvents scans every ".event" anchor in doc, groups them by
+// Cybozu sEID and, for each distinct sEID, fetches its ScheduleModify page
+// once to find out whether it's a repeat event. Non-repeating sEIDs are
+// left out of the returned map so the caller falls back to the existing
+// per-day Upsert.
+//
+// Returned events use the base sEID (no date suffix) as their Id, since a
+// repeat series is a single Google event rather than one event per day.
+func fetchRecurringEvents(agsessid string, doc *goquery.Document) map[string]*calendar.Event {
+	type occurrence struct {
+		sEID  string
+		year  int
+		month int
+		day   int
+		title string
+	}
+
+	firstSeen := map[string]occurrence{}
+	doc.Find(".event").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		sEID := sEIDFromHref(href)
+		if sEID == "" {
+			return
+		}
+		if _, ok := firstSeen[sEID]; ok {
+			return
+		}
+		re := regexp.MustCompile(`Date=da\.(\d{4})\.(\d{1,2})\.(\d{1,2})`)
+		m := re.FindStringSubmatch(href)
+		if m == nil {
+			return
+		}
+		year, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		day, _ := strconv.Atoi(m[3])
+		firstSeen[sEID] = occurrence{sEID: sEID, year: year, month: month, day: day, title: s.Find(".eventTitle").Text()}
+	})
+
+	result := map[string]*calendar.Event{}
+	var mu sync.Mutex
+	var waitGroup sync.WaitGroup
+	for _, occ := range firstSeen {
+		waitGroup.Add(1)
+		go func(occ occurrence) {
+			defer waitGroup.Done()
+			event, err := buildRecurringEvent(agsessid, occ.sEID, occ.year, occ.month, occ.day, occ.title)
+			if err != nil {
+				log.Warn().Err(err).Msgf("Unable to check repeat schedule for sEID=%v", occ.sEID)
+				return
+			}
+			if event == nil {
+				return
+			}
+			mu.Lock()
+			result[occ.sEID] = event
+			mu.Unlock()
+		}(occ)
+	}
+	waitGroup.Wait()
+
+	return result
+}
+
+// buildRecurringEvent fetches the ScheduleModify page for sEID and, if it
+// describes a repeating schedule, returns the single calendar.Event that
+// represents the whole series. It returns (nil, nil) for one-off events.
+func buildRecurringEvent(agsessid, sEID string, year, month, day int, title string) (*calendar.Event, error) {
+	url := fmt.Sprintf("%s?page=ScheduleModify&sEID=%s", baseURL, sEID)
+	node, err := cybozuHtml(agsessid, cybozuUserID, userID, url)
+	if err != nil {
+		return nil, err
+	}
+	doc := goquery.NewDocumentFromNode(node)
+
+	repeat, err := parseRepeatForm(doc)
+	if err != nil {
+		return nil, err
+	}
+	if repeat == nil {
+		return nil, nil
+	}
+
+	loc, _ := time.LoadLocation("Asia/Tokyo")
+	startYear, yearErr := selectedIntValue(doc, "SetDate.Year")
+	startMonth, monthErr := selectedIntValue(doc, "SetDate.Month")
+	startDay, dayErr := selectedIntValue(doc, "SetDate.Day")
+	if yearErr != nil || monthErr != nil || dayErr != nil {
+		// Fall back to the day we scraped this sEID from. The form's own
+		// start-date field is what keeps Dtstart (and therefore the RRULE
+		// expansion) stable across runs; this path only fires if Cybozu
+		// ever serves a ScheduleModify page without it.
+		log.Warn().Msgf("ScheduleModify page for sEID=%v has no start-date field, using scraped date", sEID)
+		startYear, startMonth, startDay = year, month, day
+	}
+	dtstart := time.Date(startYear, time.Month(startMonth), startDay, 0, 0, 0, 0, loc)
+
+	rule, err := rrule.NewRRule(rrule.ROption{
+		Freq:       repeat.Freq,
+		Interval:   repeat.Interval,
+		Byweekday:  repeat.Byweekday,
+		Bymonthday: repeat.Bymonthday,
+		Dtstart:    dtstart,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid repeat rule for sEID=%v: %v", sEID, err)
+	}
+
+	recurrence := []string{"RRULE:" + rruleOnly(rule.String())}
+	for _, exdate := range repeat.ExceptionDates {
+		recurrence = append(recurrence, "EXDATE;VALUE=DATE:"+exdate.Format("20060102"))
+	}
+
+	event := &calendar.Event{
+		Id:      sEID,
+		Summary: title,
+		Start: &calendar.EventDateTime{
+			Date:     dtstart.Format("2006-01-02"),
+			TimeZone: "Asia/Tokyo",
+		},
+		End: &calendar.EventDateTime{
+			Date:     dtstart.Format("2006-01-02"),
+			TimeZone: "Asia/Tokyo",
+		},
+		Recurrence: recurrence,
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{"cybozuKind": "event", "cybozuSEID": sEID},
+		},
+	}
+
+	if detail, err := fetchEventDetails(agsessid, sEID, dtstart.Format("2006.01.02")); err != nil {
+		log.Warn().Err(err).Msgf("Unable to fetch recurring event details for sEID=%v", sEID)
+	} else {
+		event.Description = detail.Description
+		event.Location = detail.Location
+		event.Attendees = detail.Attendees
+	}
+
+	return event, nil
+}
+
+// rruleOnly strips a leading "DTSTART...\n" line that rrule-go's
+// RRule.String() includes, keeping only the "RRULE:..." part we want to
+// store in calendar.Event.Recurrence.
+func rruleOnly(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(line, "RRULE:") {
+			return strings.TrimPrefix(line, "RRULE:")
+		}
+	}
+	return s
+}
+
+// repeatSchedule is the repeat form on Cybozu's ScheduleModify page,
+// translated to rrule-go terms.
+type repeatSchedule struct {
+	Freq           rrule.Frequency
+	Interval       int
+	Byweekday      []rrule.Weekday
+	Bymonthday     []int
+	ExceptionDates []time.Time
+}
+
+var weekdayByIndex = []rrule.Weekday{rrule.SU, rrule.MO, rrule.TU, rrule.WE, rrule.TH, rrule.FR, rrule.SA}
+
+// parseRepeatForm reads the "繰り返し" section of a ScheduleModify page.
+// It returns (nil, nil) when the event doesn't repeat.
+func parseRepeatForm(doc *goquery.Document) (*repeatSchedule, error) {
+	kind, err := selectedValue(doc, "Every.Type")
+	if err != nil {
+		// No repeat section on this page at all: treat as a one-off event.
+		return nil, nil
+	}
+
+	switch {
+	case strings.Contains(kind, "しない"):
+		return nil, nil
+
+	case strings.Contains(kind, "曜日"):
+		var byweekday []rrule.Weekday
+		doc.Find("input[name='Every.Week']:checked").Each(func(i int, s *goquery.Selection) {
+			value, _ := s.Attr("value")
+			idx, err := strconv.Atoi(value)
+			if err == nil && idx >= 0 && idx < len(weekdayByIndex) {
+				byweekday = append(byweekday, weekdayByIndex[idx])
+			}
+		})
+		return &repeatSchedule{Freq: rrule.WEEKLY, Interval: 1, Byweekday: byweekday, ExceptionDates: exceptionDates(doc)}, nil
+
+	case strings.Contains(kind, "日毎") || strings.Contains(kind, "日ごと"):
+		interval, err := intFieldValue(doc, "Every.DayInterval")
+		if err != nil || interval <= 0 {
+			interval = 1
+		}
+		return &repeatSchedule{Freq: rrule.DAILY, Interval: interval, ExceptionDates: exceptionDates(doc)}, nil
+
+	case strings.Contains(kind, "月末"):
+		return &repeatSchedule{Freq: rrule.MONTHLY, Interval: 1, Bymonthday: []int{-1}, ExceptionDates: exceptionDates(doc)}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported repeat type: %q", kind)
+	}
+}
+
+// exceptionDates reads Cybozu's 休止日 (skip date) rows, e.g.
+// <span class="exceptDate">2024/01/01</span>.
+func exceptionDates(doc *goquery.Document) []time.Time {
+	loc, _ := time.LoadLocation("Asia/Tokyo")
+	var dates []time.Time
+	doc.Find(".exceptDate").Each(func(i int, s *goquery.Selection) {
+		t, err := time.ParseInLocation("2006/01/02", strings.TrimSpace(s.Text()), loc)
+		if err == nil {
+			dates = append(dates, t)
+		}
+	})
+	return dates
+}
+
+// selectedValue returns the text of the <option selected> for the named
+// <select>, mirroring selectedIntValue but for non-numeric option text.
+func selectedValue(doc *goquery.Document, name string) (string, error) {
+	var r string
+	found := false
+	doc.Find(fmt.Sprintf("select[name='%s'] option", name)).Each(func(i int, s *goquery.Selection) {
+		if _, ok := s.Attr("selected"); ok {
+			found = true
+			r = strings.TrimSpace(s.Text())
+		}
+	})
+	if found {
+		return r, nil
+	}
+	return "", fmt.Errorf("selected value doesn't exist for '%s'", name)
+}
+
+// intFieldValue reads a plain <input name="..."> numeric text field, e.g.
+// the "N" in "N日ごと".
+func intFieldValue(doc *goquery.Document, name string) (int, error) {
+	value, ok := doc.Find(fmt.Sprintf("input[name='%s']", name)).First().Attr("value")
+	if !ok {
+		return 0, fmt.Errorf("field doesn't exist for '%s'", name)
+	}
+	return strconv.Atoi(strings.TrimSpace(value))
+}
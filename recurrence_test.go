@@ -0,0 +1,139 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/teambition/rrule-go"
+)
+
+func parseRepeatFormHTML(t *testing.T, html string) (*repeatSchedule, error) {
+	t.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatalf("invalid test HTML: %v", err)
+	}
+	return parseRepeatForm(doc)
+}
+
+func TestParseRepeatFormNoRepeatSection(t *testing.T) {
+	repeat, err := parseRepeatFormHTML(t, `<html><body></body></html>`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if repeat != nil {
+		t.Errorf("got %+v, want nil", repeat)
+	}
+}
+
+func TestParseRepeatFormNone(t *testing.T) {
+	repeat, err := parseRepeatFormHTML(t, `
+		<select name="Every.Type">
+			<option selected>しない</option>
+			<option>毎日</option>
+		</select>`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if repeat != nil {
+		t.Errorf("got %+v, want nil", repeat)
+	}
+}
+
+func TestParseRepeatFormWeekly(t *testing.T) {
+	repeat, err := parseRepeatFormHTML(t, `
+		<select name="Every.Type">
+			<option selected>曜日</option>
+		</select>
+		<input type="checkbox" name="Every.Week" value="1" checked>
+		<input type="checkbox" name="Every.Week" value="3" checked>
+		<input type="checkbox" name="Every.Week" value="5">`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if repeat == nil {
+		t.Fatal("got nil, want a weekly schedule")
+	}
+	if repeat.Freq != rrule.WEEKLY {
+		t.Errorf("Freq = %v, want WEEKLY", repeat.Freq)
+	}
+	want := []rrule.Weekday{rrule.MO, rrule.WE}
+	if len(repeat.Byweekday) != len(want) || repeat.Byweekday[0] != want[0] || repeat.Byweekday[1] != want[1] {
+		t.Errorf("Byweekday = %v, want %v", repeat.Byweekday, want)
+	}
+}
+
+func TestParseRepeatFormDailyInterval(t *testing.T) {
+	repeat, err := parseRepeatFormHTML(t, `
+		<select name="Every.Type">
+			<option selected>日ごと</option>
+		</select>
+		<input name="Every.DayInterval" value="3">`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if repeat == nil {
+		t.Fatal("got nil, want a daily schedule")
+	}
+	if repeat.Freq != rrule.DAILY || repeat.Interval != 3 {
+		t.Errorf("got Freq=%v Interval=%v, want DAILY/3", repeat.Freq, repeat.Interval)
+	}
+}
+
+func TestParseRepeatFormDailyDefaultsIntervalToOne(t *testing.T) {
+	repeat, err := parseRepeatFormHTML(t, `
+		<select name="Every.Type">
+			<option selected>日毎</option>
+		</select>`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if repeat == nil || repeat.Interval != 1 {
+		t.Errorf("got %+v, want Interval=1", repeat)
+	}
+}
+
+func TestParseRepeatFormEndOfMonth(t *testing.T) {
+	repeat, err := parseRepeatFormHTML(t, `
+		<select name="Every.Type">
+			<option selected>月末</option>
+		</select>`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if repeat == nil {
+		t.Fatal("got nil, want a monthly schedule")
+	}
+	if repeat.Freq != rrule.MONTHLY || len(repeat.Bymonthday) != 1 || repeat.Bymonthday[0] != -1 {
+		t.Errorf("got %+v, want MONTHLY/[-1]", repeat)
+	}
+}
+
+func TestParseRepeatFormUnsupportedKind(t *testing.T) {
+	_, err := parseRepeatFormHTML(t, `
+		<select name="Every.Type">
+			<option selected>毎年</option>
+		</select>`)
+	if err == nil {
+		t.Fatal("expected an error for an unsupported repeat type")
+	}
+}
+
+func TestParseRepeatFormExceptionDates(t *testing.T) {
+	repeat, err := parseRepeatFormHTML(t, `
+		<select name="Every.Type">
+			<option selected>日毎</option>
+		</select>
+		<span class="exceptDate">2024/01/01</span>
+		<span class="exceptDate">2024/02/14</span>`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(repeat.ExceptionDates) != 2 {
+		t.Fatalf("got %d exception dates, want 2", len(repeat.ExceptionDates))
+	}
+	if got := repeat.ExceptionDates[0].Format("2006-01-02"); got != "2024-01-01" {
+		t.Errorf("ExceptionDates[0] = %v, want 2024-01-01", got)
+	}
+}
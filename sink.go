@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/api/calendar/v3"
+)
+
+// CalendarSink is the destination that scraped Cybozu events are synced to.
+// GoogleCalendar, ICalSink and CalDAVSink all implement it so the scraper
+// itself never needs to know which calendar system it's writing to.
+type CalendarSink interface {
+	Upsert(event *calendar.Event) error
+	DeleteEvent(event *calendar.Event) error
+}
+
+// getSink builds the CalendarSink selected via C2G_SINK ("gcal", "ics" or
+// "caldav"). It defaults to "gcal" so existing setups keep working untouched.
+func getSink() CalendarSink {
+	switch sink := os.Getenv("C2G_SINK"); sink {
+	case "", "gcal":
+		return getGcal()
+	case "ics":
+		return getICalSink()
+	case "caldav":
+		return getCalDAVSink()
+	default:
+		log.Fatal().Msgf("Unknown C2G_SINK: %v", sink)
+		return nil
+	}
+}
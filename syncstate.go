@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// CachedEvent is what we remember about a Cybozu-originated event between
+// runs, so we can tell whether it changed and which Google event to
+// update or delete.
+type CachedEvent struct {
+	GoogleEventID string `json:"googleEventId"`
+	ETag          string `json:"etag"`
+	ContentHash   string `json:"contentHash"`
+}
+
+// SyncState is the persistent state that makes a run incremental: the
+// syncToken from the last Events.List call, plus a cache of every
+// Cybozu-originated event we've upserted. It's stored next to token.json.
+type SyncState struct {
+	NextSyncToken string                 `json:"nextSyncToken"`
+	Events        map[string]CachedEvent `json:"events"`
+
+	mu sync.Mutex
+}
+
+func newSyncState() *SyncState {
+	return &SyncState{Events: map[string]CachedEvent{}}
+}
+
+func syncStateFilePath() string {
+	return configFilePath("sync_state.json")
+}
+
+func loadSyncState() (*SyncState, error) {
+	f, err := os.Open(syncStateFilePath())
+	if os.IsNotExist(err) {
+		return newSyncState(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	state := newSyncState()
+	if err := json.NewDecoder(f).Decode(state); err != nil {
+		return nil, err
+	}
+	if state.Events == nil {
+		state.Events = map[string]CachedEvent{}
+	}
+	return state, nil
+}
+
+func (this *SyncState) save() error {
+	f, err := os.Create(syncStateFilePath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(this)
+}
+
+func (this *SyncState) get(cybozuEventID string) (CachedEvent, bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	cached, ok := this.Events[cybozuEventID]
+	return cached, ok
+}
+
+func (this *SyncState) set(cybozuEventID string, cached CachedEvent) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.Events[cybozuEventID] = cached
+}
+
+func (this *SyncState) delete(cybozuEventID string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	delete(this.Events, cybozuEventID)
+}
+
+// ids returns a snapshot of every cybozuEventID currently cached, safe to
+// range over while other goroutines mutate the state.
+func (this *SyncState) ids() []string {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	ids := make([]string, 0, len(this.Events))
+	for id := range this.Events {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (this *SyncState) reset() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.NextSyncToken = ""
+	this.Events = map[string]CachedEvent{}
+}
+
+// contentHash hashes the fields of event that matter for deciding whether
+// it needs to be re-upserted.
+func contentHash(event *calendar.Event) string {
+	attendeeEmails := make([]string, len(event.Attendees))
+	for i, attendee := range event.Attendees {
+		attendeeEmails[i] = attendee.Email
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00%v\x00%s\x00%s\x00%v",
+		event.Summary, event.Start.Date, event.Start.DateTime,
+		event.End.Date, event.End.DateTime, event.Recurrence,
+		event.Description, event.Location, attendeeEmails)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// syncEvent upserts event unless its content hash matches what we already
+// pushed last time. It reports whether an upsert actually happened, so
+// callers can keep a running count for the sync summary.
+func syncEvent(gcal CalendarSink, state *SyncState, event *calendar.Event) bool {
+	if event == nil {
+		return false
+	}
+
+	hash := contentHash(event)
+	if cached, ok := state.get(event.Id); ok && cached.ContentHash == hash {
+		log.Debug().Msgf("Skip unchanged event %v", event.Id)
+		return false
+	}
+
+	if err := gcal.Upsert(event); err != nil {
+		log.Error().Err(err).Msgf("Unable to upsert event '%v'", event.Summary)
+		return false
+	}
+	log.Info().Msgf("Succeed to update event %v", event.Summary)
+	eventsUpsertedTotal.Inc()
+	state.set(event.Id, CachedEvent{GoogleEventID: event.Id, ContentHash: hash})
+	return true
+}
+
+// cleanupStaleEvents deletes cached Cybozu events that no longer appear in
+// this run's scrape, and drops them from state. It returns how many were
+// deleted, for the sync summary.
+func cleanupStaleEvents(gcal CalendarSink, state *SyncState, seen map[string]bool) int {
+	deleted := 0
+	for _, id := range state.ids() {
+		if seen[id] {
+			continue
+		}
+		if err := gcal.DeleteEvent(&calendar.Event{Id: id}); err != nil {
+			log.Error().Err(err).Msgf("Unable to delete stale cybozu event %v", id)
+			continue
+		}
+		state.delete(id)
+		log.Info().Msgf("deleted stale cybozu event: %v", id)
+		deleted++
+	}
+	return deleted
+}
+
+// cybozuSEIDOf reads back the private.cybozuSEID extended property we tag
+// every Cybozu-managed event with, so we never touch events we don't own.
+func cybozuSEIDOf(event *calendar.Event) string {
+	if event.ExtendedProperties == nil || event.ExtendedProperties.Private == nil {
+		return ""
+	}
+	return event.ExtendedProperties.Private["cybozuSEID"]
+}
+
+// pullServerChanges learns what the user edited directly on Google since
+// the last run via the incremental sync token, refreshing state's cache
+// and, for cybozu-managed events the user deleted on Google, dropping
+// them from the cache so they get recreated.
+func (this *GoogleCalendar) pullServerChanges(state *SyncState) error {
+	call := this.svc.Events.List(this.calendarId)
+	if state.NextSyncToken != "" {
+		call = call.SyncToken(state.NextSyncToken)
+	}
+
+	var events *calendar.Events
+	err := doWithBackoff(func() error {
+		var err error
+		events, err = call.Do()
+		return err
+	})
+	if err != nil {
+		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == 410 {
+			log.Warn().Msg("Sync token expired (410 Gone), falling back to a full list")
+			state.reset()
+			return this.pullServerChanges(state)
+		}
+		return err
+	}
+
+	for _, item := range events.Items {
+		sEID := cybozuSEIDOf(item)
+		if sEID == "" {
+			continue // not a Cybozu-managed event: never touch it
+		}
+		if item.Status == "cancelled" {
+			state.delete(item.Id)
+			log.Info().Msgf("Cybozu event %v was removed on Google; will recreate it", item.Id)
+			continue
+		}
+		cached, _ := state.get(item.Id)
+		cached.GoogleEventID = item.Id
+		cached.ETag = item.Etag
+		state.set(item.Id, cached)
+	}
+
+	state.NextSyncToken = events.NextSyncToken
+	return nil
+}
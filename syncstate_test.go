@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestContentHashStableForIdenticalEvents(t *testing.T) {
+	a := &calendar.Event{
+		Summary: "Weekly sync",
+		Start:   &calendar.EventDateTime{DateTime: "2024-01-01T10:00:00+09:00"},
+		End:     &calendar.EventDateTime{DateTime: "2024-01-01T11:00:00+09:00"},
+		Attendees: []*calendar.EventAttendee{
+			{Email: "alice@example.com"},
+		},
+	}
+	b := &calendar.Event{
+		Summary: "Weekly sync",
+		Start:   &calendar.EventDateTime{DateTime: "2024-01-01T10:00:00+09:00"},
+		End:     &calendar.EventDateTime{DateTime: "2024-01-01T11:00:00+09:00"},
+		Attendees: []*calendar.EventAttendee{
+			{Email: "alice@example.com"},
+		},
+	}
+
+	if contentHash(a) != contentHash(b) {
+		t.Errorf("contentHash differs for events with identical content")
+	}
+}
+
+func TestContentHashChangesWithFields(t *testing.T) {
+	base := &calendar.Event{
+		Summary: "Weekly sync",
+		Start:   &calendar.EventDateTime{Date: "2024-01-01"},
+		End:     &calendar.EventDateTime{Date: "2024-01-01"},
+	}
+	baseHash := contentHash(base)
+
+	cases := map[string]*calendar.Event{
+		"summary changed": {
+			Summary: "Weekly sync (renamed)",
+			Start:   &calendar.EventDateTime{Date: "2024-01-01"},
+			End:     &calendar.EventDateTime{Date: "2024-01-01"},
+		},
+		"description added": {
+			Summary:     "Weekly sync",
+			Start:       &calendar.EventDateTime{Date: "2024-01-01"},
+			End:         &calendar.EventDateTime{Date: "2024-01-01"},
+			Description: "Now with a memo",
+		},
+		"location added": {
+			Summary:  "Weekly sync",
+			Start:    &calendar.EventDateTime{Date: "2024-01-01"},
+			End:      &calendar.EventDateTime{Date: "2024-01-01"},
+			Location: "Room A",
+		},
+		"attendee added": {
+			Summary:   "Weekly sync",
+			Start:     &calendar.EventDateTime{Date: "2024-01-01"},
+			End:       &calendar.EventDateTime{Date: "2024-01-01"},
+			Attendees: []*calendar.EventAttendee{{Email: "bob@example.com"}},
+		},
+		"recurrence added": {
+			Summary:    "Weekly sync",
+			Start:      &calendar.EventDateTime{Date: "2024-01-01"},
+			End:        &calendar.EventDateTime{Date: "2024-01-01"},
+			Recurrence: []string{"RRULE:FREQ=WEEKLY"},
+		},
+	}
+
+	for name, event := range cases {
+		if contentHash(event) == baseHash {
+			t.Errorf("%s: contentHash did not change", name)
+		}
+	}
+}
+
+func TestCleanupStaleEventsDeletesOnlyUnseen(t *testing.T) {
+	state := newSyncState()
+	state.set("seen1", CachedEvent{GoogleEventID: "seen1"})
+	state.set("stale1", CachedEvent{GoogleEventID: "stale1"})
+	state.set("stale2", CachedEvent{GoogleEventID: "stale2"})
+
+	sink := &fakeSink{}
+	seen := map[string]bool{"seen1": true}
+
+	deleted := cleanupStaleEvents(sink, state, seen)
+
+	if deleted != 2 {
+		t.Errorf("got %d deleted, want 2", deleted)
+	}
+	if _, ok := state.get("seen1"); !ok {
+		t.Errorf("seen1 should not have been dropped from state")
+	}
+	if _, ok := state.get("stale1"); ok {
+		t.Errorf("stale1 should have been dropped from state")
+	}
+	if _, ok := state.get("stale2"); ok {
+		t.Errorf("stale2 should have been dropped from state")
+	}
+}
+
+// fakeSink is a CalendarSink that just records what it's asked to delete.
+type fakeSink struct {
+	deleted []string
+}
+
+func (s *fakeSink) Upsert(event *calendar.Event) error { return nil }
+
+func (s *fakeSink) DeleteEvent(event *calendar.Event) error {
+	s.deleted = append(s.deleted, event.Id)
+	return nil
+}